@@ -0,0 +1,224 @@
+//  Copyright 2020 Winfried Klum
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/klumw/cpt/internal/output"
+	"github.com/urfave/cli/v2"
+)
+
+func newCountCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "count",
+		Usage: "print workflow instance counts by state",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "state", Value: "ERROR", Usage: "workflow instance state. Possible states:" + fmt.Sprint(states)},
+		},
+		Action: func(c *cli.Context) error {
+			count(c.String("state"), outputFormat(c))
+			return nil
+		},
+	}
+}
+
+func newBrokenCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "broken",
+		Usage: "list or count broken workflow instances",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "exception-pattern", Usage: "filter search pattern for broken workflow instance exception message"},
+			&cli.StringFlag{Name: "error-time-start", Usage: "filter on workflow error time, intervall start time in timestamp format, e.g. 2020-04-25 11:40:40.78"},
+			&cli.StringFlag{Name: "error-time-end", Usage: "filter on workflow error time, intervall end time in timestamp format, e.g. 2020-04-26 11:40:40.78"},
+			&cli.BoolFlag{Name: "print-count", Usage: "print number of (filtered) broken workflow instances"},
+			&cli.StringFlag{Name: "workflow-class", Usage: "filter on workflow instance class, full package name required, e.g. org.foo.wf.MyWorkflow"},
+		},
+		Action: func(c *cli.Context) error {
+			broken(c.String("exception-pattern"), c.String("error-time-start"), c.String("error-time-end"), c.Bool("print-count"), c.String("workflow-class"), outputFormat(c))
+			return nil
+		},
+	}
+}
+
+func newShowCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "show",
+		Usage:     "show workflow instance details, data and/or audit trail",
+		ArgsUsage: "<id>...",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "workflow-data", Usage: "show workflow instance data (only available for data in JSON format)"},
+			&cli.BoolFlag{Name: "audit-trail", Usage: "show audit trail messages"},
+			&cli.BoolFlag{Name: "instance-details", Usage: "show workflow instance details"},
+			&cli.BoolFlag{Name: "print-data-array", Usage: "print workflow data list as json array, only valid in combination with -workflow-data flag"},
+		},
+		Action: func(c *cli.Context) error {
+			show(c.Bool("workflow-data"), idArgs(c), c.Bool("audit-trail"), c.Bool("instance-details"), c.Bool("print-data-array"), outputFormat(c))
+			return nil
+		},
+	}
+}
+
+func newDeleteCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "delete",
+		Usage:     "delete workflow instances and their dependent rows",
+		ArgsUsage: "<id>...",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "dry-run", Usage: "print affected row counts without deleting anything"},
+		},
+		Action: func(c *cli.Context) error {
+			format := outputFormat(c)
+			dryRun := c.Bool("dry-run")
+			ok, failed := delete(idArgs(c), format, dryRun)
+			if format == output.Text && !dryRun {
+				fmt.Printf("%d succeeded, %d failed\n", ok, failed)
+			}
+			return nil
+		},
+	}
+}
+
+func newRestartCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "restart",
+		Usage:     "restart broken or invalid workflow instances",
+		ArgsUsage: "<id>...",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "dry-run", Usage: "print affected row counts without restarting anything"},
+		},
+		Action: func(c *cli.Context) error {
+			format := outputFormat(c)
+			dryRun := c.Bool("dry-run")
+			ok, failed := restart(idArgs(c), format, dryRun)
+			if format == output.Text && !dryRun {
+				fmt.Printf("%d succeeded, %d failed\n", ok, failed)
+			}
+			return nil
+		},
+	}
+}
+
+func newDataCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "data",
+		Usage: "find workflow instances by a jsonb selector (only available for data in JSON format)",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "json-selector", Usage: "json selector, e.g. json->'foo'='bar'"},
+			&cli.StringFlag{Name: "state", Value: "ERROR", Usage: "workflow instance state. Possible states:" + fmt.Sprint(states)},
+		},
+		Action: func(c *cli.Context) error {
+			selector := c.String("json-selector")
+			jsonData(&selector, c.String("state"), outputFormat(c))
+			return nil
+		},
+	}
+}
+
+func newCleanupCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "cleanup",
+		Usage: "delete workflow instance and/or audit trail data older than an age cutoff",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "age", Usage: "filter on age. Format as timestamp, days(d) or hours(h), e.g. 2006-01-02 15:04:05.99, 35d, 24h"},
+			&cli.BoolFlag{Name: "audit-trail", Usage: "delete audit trail data older than age"},
+			&cli.BoolFlag{Name: "workflow-instance", Usage: "delete workflow instance data older than age"},
+			&cli.BoolFlag{Name: "dry-run", Usage: "print affected row counts without deleting anything"},
+		},
+		Action: func(c *cli.Context) error {
+			cleanup(c.String("age"), c.Bool("audit-trail"), c.Bool("workflow-instance"), outputFormat(c), c.Bool("dry-run"))
+			return nil
+		},
+	}
+}
+
+func newServeCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "serve Copper workflow health as Prometheus metrics on /metrics",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "listen-addr", Value: ":9090", Usage: "address to serve /metrics, /healthz and /readyz on"},
+			&cli.DurationFlag{Name: "scrape-interval", Value: 15 * time.Second, Usage: "interval at which Copper state is polled and the Prometheus gauges are refreshed"},
+		},
+		Action: func(c *cli.Context) error {
+			serve(c.String("listen-addr"), c.Duration("scrape-interval"))
+			return nil
+		},
+	}
+}
+
+func newTailCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "tail",
+		Usage: "stream newly-broken workflow instances in real time",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "install-triggers", Usage: "install the copper_error/copper_audit LISTEN/NOTIFY triggers and exit"},
+			&cli.DurationFlag{Name: "poll-interval", Value: 5 * time.Second, Usage: "polling interval; LISTEN/NOTIFY notifications, when the triggers are installed, trigger additional polls"},
+			&cli.StringFlag{Name: "filter-class", Usage: "filter on workflow instance class, full package name required, e.g. org.foo.wf.MyWorkflow"},
+			&cli.StringFlag{Name: "filter-state", Usage: "filter on workflow instance state. Possible states:" + fmt.Sprint(states)},
+			&cli.StringFlag{Name: "exception-pattern", Usage: "filter search pattern for broken workflow instance exception message"},
+		},
+		Action: func(c *cli.Context) error {
+			if c.Bool("install-triggers") {
+				installTailTriggers()
+				return nil
+			}
+			tail(c.String("database-url"), c.Duration("poll-interval"), c.String("filter-class"), c.String("filter-state"), c.String("exception-pattern"), outputFormat(c))
+			return nil
+		},
+	}
+}
+
+func newCompletionCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "completion",
+		Usage: "generate shell completion scripts",
+		Subcommands: []*cli.Command{
+			{Name: "bash", Usage: "print a bash completion script", Action: func(c *cli.Context) error { fmt.Print(bashCompletionScript); return nil }},
+			{Name: "zsh", Usage: "print a zsh completion script", Action: func(c *cli.Context) error { fmt.Print(zshCompletionScript); return nil }},
+			{Name: "fish", Usage: "print a fish completion script", Action: func(c *cli.Context) error { fmt.Print(fishCompletionScript); return nil }},
+		},
+	}
+}
+
+// bashCompletionScript/zshCompletionScript delegate to the app's own
+// --generate-bash-completion flag (enabled via cli.App.EnableBashCompletion),
+// the mechanism urfave/cli itself uses for shell completion.
+const bashCompletionScript = `#! /bin/bash
+_cli_bash_autocomplete() {
+    local cur opts base
+    COMPREPLY=()
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    opts=$( "${COMP_WORDS[0]}" "${COMP_WORDS[@]:1:$COMP_CWORD-1}" --generate-bash-completion )
+    COMPREPLY=( $(compgen -W "${opts}" -- ${cur}) )
+    return 0
+}
+complete -F _cli_bash_autocomplete cpt
+`
+
+const zshCompletionScript = `#compdef cpt
+autoload -U compinit && compinit
+autoload -U bashcompinit && bashcompinit
+` + bashCompletionScript
+
+const fishCompletionScript = `function __complete_cpt
+    set -lx COMP_LINE (commandline -cp)
+    test -z (commandline -ct)
+    and set COMP_LINE "$COMP_LINE "
+    cpt --generate-bash-completion
+end
+complete -f -c cpt -a '(__complete_cpt)'
+`
@@ -20,8 +20,8 @@ import (
 	"compress/zlib"
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
-	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -32,6 +32,7 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v4"
+	"github.com/klumw/cpt/internal/output"
 )
 
 var conn *pgx.Conn
@@ -41,121 +42,7 @@ var validLike = regexp.MustCompile(`^[^"'%]+$`)
 
 const pgTimestampFormat = "2006-01-02 15:04:05.999999999"
 
-func main() {
-	var err error
-	var args []string
-
-	pArgs := readPipe()
-
-	if len(os.Args) > 2 {
-		args = os.Args[2:]
-	}
-	args = append(args, pArgs...)
-
-	countCommand := flag.NewFlagSet("count", flag.ExitOnError)
-	countStatePtr := countCommand.String("state", "ERROR", "workflow instance state. Possible states:"+fmt.Sprint(states))
-
-	brokenCommand := flag.NewFlagSet("broken", flag.ExitOnError)
-	brokenPatternPtr := brokenCommand.String("exception-pattern", "", "filter search pattern for broken workflow instance exception message")
-	brokenStartTimePtr := brokenCommand.String("error-time-start", "", "filter on workflow error time, intervall start time in timestamp format, e.g. 2020-04-25 11:40:40.78")
-	brokenEndTimePtr := brokenCommand.String("error-time-end", "", "filter on workflow error time, intervall end time in timestamp format, e.g. 2020-04-26 11:40:40.78")
-	brokenCountTimerPtr := brokenCommand.Bool("print-count", false, "Print number of (filtered) broken workflow instances")
-	brokenClassPtr := brokenCommand.String("workflow-class", "", "filter on workflow instance class, full package name required, e.g. org.foo.wf.MyWorkflow")
-
-	dataCommand := flag.NewFlagSet("data", flag.ExitOnError)
-	dataSelectorPtr := dataCommand.String("json-selector", "", "json selector, e.g. json->1='test', (only available for data in JSON format)")
-	dataStatePtr := dataCommand.String("state", "ERROR", "workflow instance state. Possible states:"+fmt.Sprint(states))
-
-	showCommand := flag.NewFlagSet("show", flag.ExitOnError)
-	showDataPtr := showCommand.Bool("workflow-data", false, "show workflow instance data (only available for data in JSON format)")
-	showAuditPtr := showCommand.Bool("audit-trail", false, "show audit trail messages")
-	showInstancePtr := showCommand.Bool("instance-details", false, "show workflow instance details")
-	showDataArrPtr := showCommand.Bool("print-data-array", false, "print workflow data list as json array, only valid in combination with -workflow-data flag")
-
-	deleteCommand := flag.NewFlagSet("delete", flag.ExitOnError)
-
-	restartCommand := flag.NewFlagSet("restart", flag.ExitOnError)
-
-	cleanupCommand := flag.NewFlagSet("cleanup", flag.ExitOnError)
-	cleanupAgePtr := cleanupCommand.String("age", "", "filter on age. Format as timestamp, days(d) or hours(h), e.g. 2006-01-02 15:04:05.99, 35d, 24h")
-	cleanupAuditPtr := cleanupCommand.Bool("audit-trail", false, "delete audit trail data older than age")
-	cleanupInstancePtr := cleanupCommand.Bool("workflow-instance", false, "delete workflow instance data older than age")
-
-	if len(os.Args) < 2 {
-		printHelp()
-		os.Exit(1)
-	}
-
-	switch os.Args[1] {
-	case "count":
-		countCommand.Parse(args)
-	case "broken":
-		brokenCommand.Parse(args)
-	case "delete":
-		deleteCommand.Parse(args)
-	case "show":
-		showCommand.Parse(args)
-	case "restart":
-		restartCommand.Parse(args)
-	case "data":
-		dataCommand.Parse(args)
-	case "cleanup":
-		cleanupCommand.Parse(args)
-	default:
-		printHelp()
-		os.Exit(1)
-	}
-
-	conn, err = pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		exitOnErr("Unable to connect to database: %v\nMake sure that DATABASE_URL environment variable is set", err)
-	}
-
-	if countCommand.Parsed() {
-		count(*countStatePtr)
-	}
-
-	if brokenCommand.Parsed() {
-		broken(*brokenPatternPtr, *brokenStartTimePtr, *brokenEndTimePtr, *brokenCountTimerPtr, *brokenClassPtr)
-	}
-
-	if deleteCommand.Parsed() {
-		args := deleteCommand.Args()
-		delete(args)
-	}
-
-	if showCommand.Parsed() {
-		args := showCommand.Args()
-		show(*showDataPtr, args, *showAuditPtr, *showInstancePtr, *showDataArrPtr)
-	}
-
-	if restartCommand.Parsed() {
-		args := restartCommand.Args()
-		restart(args)
-	}
-
-	if dataCommand.Parsed() {
-		jsonData(dataSelectorPtr, *dataStatePtr)
-	}
-
-	if cleanupCommand.Parsed() {
-		cleanup(*cleanupAgePtr, *cleanupAuditPtr, *cleanupInstancePtr)
-	}
-}
-
-func printHelp() {
-	fmt.Println("Copper Postgres Tool\nUsage:\n",
-		"count\n",
-		"broken\n",
-		"show\n",
-		"delete\n",
-		"restart\n",
-		"data (only available for Json format)\n",
-		"cleanup\n",
-		"add -help after command to get more information")
-}
-
-func count(state string) {
+func count(state string, format output.Format) {
 	var count int64
 	var err error
 	stateIdx := stateIndex(state)
@@ -172,101 +59,238 @@ func count(state string) {
 	if err != nil {
 		exitOnErr("Error reading count: %v", err)
 	}
-	fmt.Fprintf(os.Stdout, "%v\n", count)
+
+	if format == output.Text {
+		fmt.Fprintf(os.Stdout, "%v\n", count)
+		return
+	}
+	output.NewEncoder(os.Stdout, format).EmitOne(output.CountResult{State: states[stateIdx], Count: count})
 }
 
-func broken(pattern string, stime string, etime string, count bool, classname string) {
+func broken(pattern string, stime string, etime string, count bool, classname string, format output.Format) {
 
-	var sqlBuffer bytes.Buffer
+	var base string
 	if count {
-		sqlBuffer.WriteString("select count(workflow_instance_id)")
+		base = "select count(workflow_instance_id) from cop_workflow_instance_error as e, cop_workflow_instance as i where e.workflow_instance_id=i.id"
 	} else {
-		sqlBuffer.WriteString("select workflow_instance_id")
+		base = "select e.workflow_instance_id, i.classname, e.error_ts, e.exception from cop_workflow_instance_error as e, cop_workflow_instance as i where e.workflow_instance_id=i.id"
 	}
-
-	sqlBuffer.WriteString("from cop_workflow_instance_error as e, cop_workflow_instance as i where e.workflow_instance_id=i.id")
+	qb := newQueryBuilder(base)
 
 	if len(pattern) > 0 {
-		if validLike.MatchString(pattern) {
-			sqlBuffer.WriteString(" and e.exception like '%" + pattern + "%'")
-		} else {
+		if !validLike.MatchString(pattern) {
 			exitOnErr("Invalid exception-pattern: %v", pattern)
 		}
+		qb.and("e.exception like ?", "%"+pattern+"%")
 	}
 
 	if len(classname) > 0 {
-		if validClassName.MatchString(classname) {
-			sqlBuffer.WriteString(" and i.classname='" + classname + "'")
-		} else {
+		if !validClassName.MatchString(classname) {
 			exitOnErr("Invalid workflow-classname: %v", classname)
 		}
+		qb.and("i.classname=?", classname)
 	}
 
 	if len(stime) > 0 {
-		sqlBuffer.WriteString(" and e.error_ts >= '" + stime + "'")
+		qb.and("e.error_ts >= ?", stime)
 	}
 	if len(etime) > 0 {
-		sqlBuffer.WriteString(" and e.error_ts <= '" + etime + "'")
+		qb.and("e.error_ts <= ?", etime)
 	}
 
-	sqlBuffer.WriteString(";")
 	if count {
 		var ids int64
-		err := conn.QueryRow(context.Background(), sqlBuffer.String()).Scan(&ids)
+		err := conn.QueryRow(context.Background(), qb.String(), qb.Args()...).Scan(&ids)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Workflow instance error count failed: %v\n", err)
 			return
 		}
-		fmt.Println(ids)
+		if format == output.Text {
+			fmt.Println(ids)
+			return
+		}
+		output.NewEncoder(os.Stdout, format).EmitOne(output.CountResult{Count: ids})
 		return
 	}
-	rows, err := conn.Query(context.Background(), sqlBuffer.String())
+	rows, err := conn.Query(context.Background(), qb.String(), qb.Args()...)
 
 	if err != nil {
 		exitOnErr("Workflow instance error search failed: %v\n", err)
 	}
+
+	enc := output.NewEncoder(os.Stdout, format)
 	for rows.Next() {
-		var id string
-		err1 := rows.Scan(&id)
+		var id, rowClassname, exception string
+		var errorTs time.Time
+		err1 := rows.Scan(&id, &rowClassname, &errorTs, &exception)
 		if err1 != nil {
 			fmt.Fprintf(os.Stderr, "Error reading workflow instance id, error: %v", err1)
+			continue
 		}
-		fmt.Println(id)
+		if format == output.Text {
+			fmt.Println(id)
+			continue
+		}
+		enc.Emit(output.BrokenResult{ID: id, Classname: rowClassname, ErrorTs: errorTs.Format(pgTimestampFormat), Exception: exception})
 	}
+	enc.Close()
 }
 
-func delete(args []string) {
-	var err error
+func delete(args []string, format output.Format, dryRun bool) (succeeded, failed int) {
+	enc := output.NewEncoder(os.Stdout, format)
 	for _, id := range args {
-		_, err = conn.Exec(context.Background(), "select 1 from cop_workflow_instance where id=$1 for update", id)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error locking workflow instance id=%v, skipping...\n", id)
+		var counts []tableCount
+		err := withRetry(context.Background(), func(ctx context.Context) error {
+			tx, err := conn.Begin(ctx)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback(ctx)
+
+			if dryRun {
+				counts = nil
+				queries := []struct{ table, sql string }{
+					{"cop_response", "select count(*) from cop_response where correlation_id in (select correlation_id from cop_wait where workflow_instance_id=$1)"},
+					{"cop_wait", "select count(*) from cop_wait where workflow_instance_id=$1"},
+					{"cop_workflow_instance_error", "select count(*) from cop_workflow_instance_error where workflow_instance_id=$1"},
+					{"cop_workflow_instance", "select count(*) from cop_workflow_instance where id=$1"},
+				}
+				for _, q := range queries {
+					var n int64
+					if err := tx.QueryRow(ctx, q.sql, id).Scan(&n); err != nil {
+						return fmt.Errorf("counting affected rows in %v: %w", q.table, err)
+					}
+					counts = append(counts, tableCount{table: q.table, count: n})
+				}
+				return nil
+			}
+
+			if _, err := tx.Exec(ctx, "select 1 from cop_workflow_instance where id=$1 for update", id); err != nil {
+				return fmt.Errorf("locking workflow instance id=%v: %w", id, err)
+			}
+			if _, err := tx.Exec(ctx, "delete from cop_response where correlation_id in (select correlation_id from cop_wait where workflow_instance_id=$1)", id); err != nil {
+				return fmt.Errorf("deleting workflow instance from COP_RESPONSE id=%v: %w", id, err)
+			}
+			if _, err := tx.Exec(ctx, "delete from cop_wait where workflow_instance_id=$1", id); err != nil {
+				return fmt.Errorf("deleting workflow instance from COP_WAIT id=%v: %w", id, err)
+			}
+			if _, err := tx.Exec(ctx, "delete from cop_workflow_instance_error where workflow_instance_id=$1", id); err != nil {
+				return fmt.Errorf("deleting workflow instance from COP_WORKFLOW_INSTANCE_ERROR id=%v: %w", id, err)
+			}
+			if _, err := tx.Exec(ctx, "delete from cop_workflow_instance where id=$1", id); err != nil {
+				return fmt.Errorf("deleting workflow instance from COP_WORKFLOW_INSTANCE id=%v: %w", id, err)
+			}
+			return tx.Commit(ctx)
+		})
+
+		if dryRun {
+			reportDryRun(format, enc, id, counts, "delete", err)
+			if err != nil {
+				failed++
+			} else {
+				succeeded++
+			}
 			continue
 		}
-		_, err = conn.Exec(context.Background(), "delete from cop_response where correlation_id in (select correlation_id from cop_wait where workflow_instance_id=$1)", id)
+
+		var failures []string
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error deleteing workflow instance from COP_RESPONSE id=%v\n", id)
-			err = nil
+			failures = append(failures, err.Error())
+			failed++
+		} else {
+			succeeded++
 		}
-		_, err = conn.Exec(context.Background(), "delete from cop_wait where workflow_instance_id=$1", id)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error deleting workflow instance from COP_WAIT id=%v\n", id)
-			err = nil
+		reportResult(format, enc, id, failures)
+	}
+	enc.Close()
+	return succeeded, failed
+}
+
+// reportResult surfaces the outcome of a per-id operation (restart,
+// delete): in text mode it prints each failure line as before, in
+// json/ndjson mode it emits a single output.OpResult per id.
+func reportResult(format output.Format, enc *output.Encoder, id string, failures []string) {
+	if format == output.Text {
+		for _, f := range failures {
+			fmt.Fprintln(os.Stderr, f)
 		}
-		_, err = conn.Exec(context.Background(), "delete from cop_workflow_instance_error where workflow_instance_id=$1", id)
+		return
+	}
+	result := output.OpResult{ID: id, Ok: len(failures) == 0}
+	if len(failures) > 0 {
+		result.Error = strings.Join(failures, "; ")
+	}
+	enc.Emit(result)
+}
+
+// tableCount is one row-count entry of a -dry-run breakdown, e.g.
+// {"cop_wait", 3}.
+type tableCount struct {
+	table string
+	count int64
+}
+
+// reportDryRun surfaces the outcome of a -dry-run check for a per-id
+// operation (restart, delete) through the same encoder as the real run,
+// so -output json/ndjson produces one well-formed result per id instead
+// of bypassing the encoder with plain text. counts reports, per
+// dependent table, how many rows the real run would touch.
+func reportDryRun(format output.Format, enc *output.Encoder, id string, counts []tableCount, verb string, err error) {
+	if format == output.Text {
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error deleting workflow instance from COP_WORKFLOW_INSTANCE_ERROR, id=%v\n", id)
-			err = nil
+			fmt.Fprintf(os.Stderr, "Error checking workflow instance id=%v: %v\n", id, err)
+			return
 		}
-		_, err = conn.Exec(context.Background(), "delete from cop_workflow_instance where id=$1", id)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error deleting workflow instance from COP_WORKFLOW_INSTANCE, id=%v\n", id)
+		var parts []string
+		for _, c := range counts {
+			parts = append(parts, fmt.Sprintf("%s=%d", c.table, c.count))
 		}
+		fmt.Fprintf(os.Stdout, "would %v workflow instance id=%v (row counts: %v)\n", verb, id, strings.Join(parts, ", "))
+		return
+	}
+	affected := make(map[string]int64, len(counts))
+	for _, c := range counts {
+		affected[c.table] = c.count
+	}
+	result := output.OpResult{ID: id, Ok: err == nil, AffectedRows: affected}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	enc.Emit(result)
+}
 
+// reportCleanupDryRun surfaces the outcome of cleanup's -dry-run check
+// through the same output.Encoder/CleanupResult path as the real run, so
+// -output json/ndjson stays well-formed instead of bypassing the encoder
+// with plain text.
+func reportCleanupDryRun(format output.Format, atime time.Time, instance bool, instanceRows int64, audit bool, auditRows int64, err error) {
+	if format == output.Text {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error during dry-run cleanup: %v\n", err)
+			return
+		}
+		if instance {
+			fmt.Fprintf(os.Stdout, "would delete %d row(s) from cop_workflow_instance (and dependent tables) older than %v\n", instanceRows, atime)
+		}
+		if audit {
+			fmt.Fprintf(os.Stdout, "would delete %d row(s) from cop_audit_trail_event older than %v\n", auditRows, atime)
+		}
+		return
+	}
+	result := output.CleanupResult{AgeBefore: atime.Format(pgTimestampFormat), Ok: err == nil}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if instance {
+		result.InstanceRows = &instanceRows
 	}
+	if audit {
+		result.AuditRows = &auditRows
+	}
+	output.NewEncoder(os.Stdout, format).EmitOne(result)
 }
 
-func show(showData bool, args []string, audit bool, instance bool, asArray bool) {
+func show(showData bool, args []string, audit bool, instance bool, asArray bool, format output.Format) {
 	var data, ppoolID, classname string
 	var state, priority, csWaitmode, minNumbOfResp, numbOfWaits int
 	var lastModTs, creationTs time.Time
@@ -278,6 +302,44 @@ func show(showData bool, args []string, audit bool, instance bool, asArray bool)
 		exitOnErr("Flag -print-data-array is only allowed together with -workflow-data flag")
 	}
 
+	if format != output.Text {
+		enc := output.NewEncoder(os.Stdout, format)
+		for _, id := range args {
+			result := output.ShowResult{ID: id}
+			sql := "select data, state, priority, last_mod_ts, ppool_id,cs_waitmode, min_numb_of_resp, numb_of_waits, timeout, creation_ts, classname from cop_workflow_instance where id=$1"
+			err := conn.QueryRow(context.Background(), sql, id).Scan(&data, &state, &priority, &lastModTs, &ppoolID, &csWaitmode, &minNumbOfResp, &numbOfWaits, &timeout, &creationTs, &classname)
+			if err == nil {
+				if instance {
+					stateTxt, _ := indexState(state)
+					var timeoutStr *string
+					if timeout != nil {
+						s := timeout.Format(pgTimestampFormat)
+						timeoutStr = &s
+					}
+					result.Instance = &output.Instance{
+						State: stateTxt, Priority: priority, CreationTs: creationTs.Format(pgTimestampFormat),
+						LastModTs: lastModTs.Format(pgTimestampFormat), PpoolID: ppoolID, CsWaitmode: csWaitmode,
+						NumbOfWaits: numbOfWaits, Classname: classname, Timeout: timeoutStr,
+					}
+				}
+				if showData {
+					var parsed interface{}
+					if json.Unmarshal([]byte(data), &parsed) == nil {
+						result.Data = parsed
+					} else {
+						result.Data = data
+					}
+				}
+			}
+			if audit {
+				result.AuditTrail = auditEntries(id)
+			}
+			enc.Emit(result)
+		}
+		enc.Close()
+		return
+	}
+
 	if asArray {
 		instance = false
 		audit = false
@@ -306,7 +368,7 @@ func show(showData bool, args []string, audit bool, instance bool, asArray bool)
 		}
 
 		if audit {
-			auditMsgs(id)
+			printAuditTrail(id)
 		}
 
 		if i+1 < len(args) {
@@ -322,15 +384,40 @@ func show(showData bool, args []string, audit bool, instance bool, asArray bool)
 	}
 }
 
-func auditMsgs(id string) {
+// auditEntries fetches the audit trail for a workflow instance as
+// structured entries, used by the json/ndjson rendering of `show`.
+func auditEntries(id string) []output.AuditEntry {
+	var entries []output.AuditEntry
+	sql := "select long_message,occurrence from cop_audit_trail_event where instance_id=$1 order by occurrence"
+	rows, err := conn.Query(context.Background(), sql, id)
+	if err != nil {
+		return entries
+	}
+	for rows.Next() {
+		var msg string
+		var occurrence time.Time
+		if err := rows.Scan(&msg, &occurrence); err != nil {
+			fmt.Fprintf(os.Stderr, "Error retrieving audit entry:%v", err)
+			continue
+		}
+		dmsg, err := decodeMsg(msg)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, output.AuditEntry{Occurrence: occurrence.Format(pgTimestampFormat), Message: dmsg})
+	}
+	return entries
+}
 
+// printAuditTrail renders the audit trail for a workflow instance in the
+// original dashed-line text format.
+func printAuditTrail(id string) {
 	first := true
 	sql := "select long_message,occurrence from cop_audit_trail_event where instance_id=$1 order by occurrence"
 	rows, err := conn.Query(context.Background(), sql, id)
 	if err != nil {
 		return
 	}
-
 	for rows.Next() {
 		if first {
 			fmt.Println("Audit Trail:")
@@ -339,7 +426,6 @@ func auditMsgs(id string) {
 		var msg string
 		var occurrence time.Time
 		err1 := rows.Scan(&msg, &occurrence)
-
 		if err1 != nil {
 			fmt.Fprintf(os.Stderr, "Error retrieving audit entry:%v", err1)
 			continue
@@ -351,29 +437,75 @@ func auditMsgs(id string) {
 	}
 }
 
-func restart(args []string) {
+func restart(args []string, format output.Format, dryRun bool) (succeeded, failed int) {
+	enc := output.NewEncoder(os.Stdout, format)
 	for _, id := range args {
-		now := time.Now()
-		sql := "insert into cop_queue (ppool_id, priority, last_mod_ts, workflow_instance_id) (select ppool_id, priority, $1, id from cop_workflow_instance where id=$2 and (state=4 or state=5))"
-		_, err := conn.Exec(context.Background(), sql, now, id)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error restarting workflow instance: %v, %v", id, err)
-			continue
-		}
-		sql = "update cop_workflow_instance set state=0, last_mod_ts=$1 where id=$2 and (state=4 or state=4)"
-		_, err = conn.Exec(context.Background(), sql, now, id)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error restarting workflow instance:%v, %v", id, err)
+		var counts []tableCount
+		err := withRetry(context.Background(), func(ctx context.Context) error {
+			tx, err := conn.Begin(ctx)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback(ctx)
+
+			if dryRun {
+				counts = nil
+				queries := []struct{ table, sql string }{
+					{"cop_workflow_instance", "select count(*) from cop_workflow_instance where id=$1 and (state=4 or state=5)"},
+					{"cop_workflow_instance_error", "select count(*) from cop_workflow_instance_error where workflow_instance_id=$1"},
+				}
+				for _, q := range queries {
+					var n int64
+					if err := tx.QueryRow(ctx, q.sql, id).Scan(&n); err != nil {
+						return fmt.Errorf("counting affected rows in %v: %w", q.table, err)
+					}
+					counts = append(counts, tableCount{table: q.table, count: n})
+				}
+				return nil
+			}
+
+			now := time.Now()
+			sql := "insert into cop_queue (ppool_id, priority, last_mod_ts, workflow_instance_id) (select ppool_id, priority, $1, id from cop_workflow_instance where id=$2 and (state=4 or state=5))"
+			if _, err := tx.Exec(ctx, sql, now, id); err != nil {
+				return fmt.Errorf("restarting workflow instance %v: %w", id, err)
+			}
+			sql = "update cop_workflow_instance set state=0, last_mod_ts=$1 where id=$2 and (state=4 or state=5)"
+			if _, err := tx.Exec(ctx, sql, now, id); err != nil {
+				return fmt.Errorf("restarting workflow instance %v: %w", id, err)
+			}
+			if _, err := tx.Exec(ctx, "delete from cop_workflow_instance_error where workflow_instance_id=$1", id); err != nil {
+				return fmt.Errorf("removing workflow instance %v from error table: %w", id, err)
+			}
+			return tx.Commit(ctx)
+		})
+
+		if dryRun {
+			reportDryRun(format, enc, id, counts, "restart", err)
+			if err != nil {
+				failed++
+			} else {
+				succeeded++
+			}
 			continue
 		}
-		_, err = conn.Exec(context.Background(), "delete from cop_workflow_instance_error where workflow_instance_id=$1", id)
+
+		var failures []string
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error removing workflow instance: %v from error table, %v", id, err)
+			failures = append(failures, err.Error())
+			failed++
+		} else {
+			succeeded++
 		}
+		reportResult(format, enc, id, failures)
 	}
+	enc.Close()
+	return succeeded, failed
 }
 
-func cleanup(age string, audit bool, instance bool) {
+// cleanup deletes workflow instance and/or audit trail data older than age
+// in a single retried transaction, so a mid-way failure can't leave
+// orphaned rows across the dependent tables.
+func cleanup(age string, audit bool, instance bool, format output.Format, dryRun bool) (succeeded, failed int) {
 
 	if len(age) < 1 {
 		exitOnErr("Flag -age is mandatory")
@@ -386,28 +518,80 @@ func cleanup(age string, audit bool, instance bool) {
 	if err != nil {
 		exitOnErr("Invalid age value %v. Use valid day(d), hours(h) or timestamp format", age)
 	}
-	if instance {
-		sql := "delete from cop_workflow_instance_error where error_ts < $1;"
-		conn.Exec(context.Background(), sql, atime)
-		sql = "delete from cop_response where response_ts < $1;"
-		conn.Exec(context.Background(), sql, atime)
-		sql = "delete from cop_wait where workflow_instance_id IN (select id from cop_workflow_instance where creation_ts < $1);"
-		conn.Exec(context.Background(), sql, atime)
-		sql = "delete from cop_adaptercall where workflowid IN (select id from cop_workflow_instance where creation_ts < $1);"
-		conn.Exec(context.Background(), sql, atime)
-		sql = "delete from cop_lock where workflow_instance_id IN (select id from cop_workflow_instance where creation_ts < $1);"
-		conn.Exec(context.Background(), sql, atime)
-		sql = "delete from cop_queue where workflow_instance_id IN (select id from cop_workflow_instance where creation_ts < $1);"
-		conn.Exec(context.Background(), sql, atime)
-		sql = "delete from cop_workflow_instance where creation_ts < $1;"
-		conn.Exec(context.Background(), sql, atime)
+
+	var instanceRows, auditRows int64
+	err = withRetry(context.Background(), func(ctx context.Context) error {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		if dryRun {
+			if instance {
+				if err := tx.QueryRow(ctx, "select count(*) from cop_workflow_instance where creation_ts < $1", atime).Scan(&instanceRows); err != nil {
+					return err
+				}
+			}
+			if audit {
+				if err := tx.QueryRow(ctx, "select count(*) from cop_audit_trail_event where occurrence < $1", atime).Scan(&auditRows); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if instance {
+			statements := []string{
+				"delete from cop_workflow_instance_error where error_ts < $1",
+				"delete from cop_response where response_ts < $1",
+				"delete from cop_wait where workflow_instance_id IN (select id from cop_workflow_instance where creation_ts < $1)",
+				"delete from cop_adaptercall where workflowid IN (select id from cop_workflow_instance where creation_ts < $1)",
+				"delete from cop_lock where workflow_instance_id IN (select id from cop_workflow_instance where creation_ts < $1)",
+				"delete from cop_queue where workflow_instance_id IN (select id from cop_workflow_instance where creation_ts < $1)",
+				"delete from cop_workflow_instance where creation_ts < $1",
+			}
+			for _, sql := range statements {
+				if _, err := tx.Exec(ctx, sql, atime); err != nil {
+					return fmt.Errorf("cleaning up workflow instance data: %w", err)
+				}
+			}
+		}
+
+		if audit {
+			if _, err := tx.Exec(ctx, "delete from cop_audit_trail_event where occurrence < $1", atime); err != nil {
+				return fmt.Errorf("cleaning up audit trail data: %w", err)
+			}
+		}
+
+		return tx.Commit(ctx)
+	})
+
+	if dryRun {
+		reportCleanupDryRun(format, atime, instance, instanceRows, audit, auditRows, err)
+		if err != nil {
+			return 0, 1
+		}
+		return 1, 0
 	}
 
-	if audit {
-		sql := "delete from cop_audit_trail_event where occurrence < $1;"
-		conn.Exec(context.Background(), sql, atime)
+	if format == output.Text {
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "cleanup failed: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stdout, "deleted data older than %v", atime)
+		}
+	} else {
+		result := output.CleanupResult{AgeBefore: atime.Format(pgTimestampFormat), Ok: err == nil}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		output.NewEncoder(os.Stdout, format).EmitOne(result)
 	}
-	fmt.Fprintf(os.Stdout, "deleted data older than %v", atime)
+	if err != nil {
+		return 0, 1
+	}
+	return 1, 0
 }
 
 func readPipe() []string {
@@ -472,39 +656,45 @@ func decodeMsg(msg string) (string, error) {
 	return string(dmsg[7:]), err
 }
 
-func jsonData(selector *string, state string) {
-	var err error
-	var sql string
-
-	if selector == nil {
+func jsonData(selector *string, state string, format output.Format) {
+	if selector == nil || len(*selector) == 0 {
 		exitOnErr("Flag -json-selector is mandatory")
 	}
 
-	stateIdx := stateIndex(state)
-	stateInt := strconv.Itoa(stateIdx)
+	parsed, err := parseJSONSelector(*selector)
+	if err != nil {
+		exitOnErr("Invalid json-selector: %v", err)
+	}
 
+	stateIdx := stateIndex(state)
 	if stateIdx < 0 {
 		exitOnErr("Invalid state: %v. Allowed states are: %v", state, fmt.Sprint(states))
 	}
 
-	if states[stateIdx] == "ALL" {
-		sql = "select id from (select id,state, data::jsonb as json from cop_workflow_instance) as r where " + *selector
-	} else {
-		sql = "select id from (select id,state, data::jsonb as json from cop_workflow_instance) as r where state=" + stateInt + " and " + *selector
+	qb := newQueryBuilder("select id from (select id, state, data::jsonb as json from cop_workflow_instance) as r where true")
+	if states[stateIdx] != "ALL" {
+		qb.and("state=?", stateIdx)
 	}
+	qb.and(parsed.expr("json"), parsed.literal)
 
-	rows, err := conn.Query(context.Background(), sql)
+	rows, err := conn.Query(context.Background(), qb.String(), qb.Args()...)
 	if err != nil {
 		exitOnErr("Query Error:%v", err)
 	}
+	enc := output.NewEncoder(os.Stdout, format)
 	for rows.Next() {
 		var id string
 		err1 := rows.Scan(&id)
-		if err1 == nil {
+		if err1 != nil {
+			continue
+		}
+		if format == output.Text {
 			fmt.Println(id)
+			continue
 		}
+		enc.Emit(output.DataResult{ID: id})
 	}
-
+	enc.Close()
 }
 
 func parseAge(age string) (time.Time, error) {
@@ -0,0 +1,163 @@
+//  Copyright 2020 Winfried Klum
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package output defines the stable JSON schema shared by every cpt
+// subcommand and a small streaming encoder on top of it, so downstream
+// tooling (jq pipelines, dashboards) has one format to parse instead of
+// the CLI's dashed-line, human-oriented separators.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Format selects how a subcommand renders its results.
+type Format string
+
+const (
+	Text   Format = "text"
+	JSON   Format = "json"
+	NDJSON Format = "ndjson"
+)
+
+// ParseFormat validates the -output flag value.
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case Text, JSON, NDJSON:
+		return Format(s), nil
+	default:
+		return "", fmt.Errorf("invalid -output %q, must be one of text, json, ndjson", s)
+	}
+}
+
+// CountResult is the JSON schema for the `count` subcommand.
+type CountResult struct {
+	State string `json:"state,omitempty"`
+	Count int64  `json:"count"`
+}
+
+// BrokenResult is the JSON schema for one row streamed by `broken` (and
+// reused by `tail` for newly-broken instances).
+type BrokenResult struct {
+	ID        string `json:"id"`
+	Classname string `json:"classname,omitempty"`
+	ErrorTs   string `json:"error_ts,omitempty"`
+	Exception string `json:"exception,omitempty"`
+}
+
+// DataResult is the JSON schema for one row streamed by `data`.
+type DataResult struct {
+	ID string `json:"id"`
+}
+
+// AuditEntry is one row of a workflow instance's audit trail.
+type AuditEntry struct {
+	Occurrence string `json:"occurrence"`
+	Message    string `json:"message"`
+}
+
+// Instance carries the workflow instance details shown by `show
+// -instance-details`.
+type Instance struct {
+	State       string  `json:"state"`
+	Priority    int     `json:"priority"`
+	CreationTs  string  `json:"creation_ts"`
+	LastModTs   string  `json:"last_mod_ts"`
+	PpoolID     string  `json:"ppool_id"`
+	CsWaitmode  int     `json:"cs_waitmode"`
+	NumbOfWaits int     `json:"numb_of_waits"`
+	Classname   string  `json:"classname"`
+	Timeout     *string `json:"timeout,omitempty"`
+}
+
+// ShowResult is the JSON schema for one workflow emitted by `show`.
+type ShowResult struct {
+	ID         string       `json:"id"`
+	Instance   *Instance    `json:"instance,omitempty"`
+	Data       interface{}  `json:"data,omitempty"`
+	AuditTrail []AuditEntry `json:"audit_trail,omitempty"`
+}
+
+// OpResult is the JSON schema for one workflow-id result returned by
+// restart and delete. AffectedRows is only populated in -dry-run mode,
+// keyed by the table the real run would have touched.
+type OpResult struct {
+	ID           string           `json:"id"`
+	Ok           bool             `json:"ok"`
+	Error        string           `json:"error,omitempty"`
+	AffectedRows map[string]int64 `json:"affected_rows,omitempty"`
+}
+
+// CleanupResult is the JSON schema returned by cleanup, which operates on
+// an age cutoff rather than a list of workflow ids. InstanceRows and
+// AuditRows are only populated in -dry-run mode, reporting the row
+// counts the real run would have touched.
+type CleanupResult struct {
+	AgeBefore    string `json:"age_before"`
+	Ok           bool   `json:"ok"`
+	Error        string `json:"error,omitempty"`
+	InstanceRows *int64 `json:"workflow_instance_rows,omitempty"`
+	AuditRows    *int64 `json:"audit_trail_rows,omitempty"`
+}
+
+// Encoder writes results in the format selected by the -output flag. In
+// Text mode Emit and Close are no-ops, leaving the subcommand free to keep
+// printing its existing prose (EmitOne still writes JSON, so callers only
+// use it outside Text mode); in NDJSON mode every Emit call writes one JSON
+// object immediately; in JSON mode, Emit buffers and Close writes a
+// single JSON array.
+type Encoder struct {
+	format Format
+	enc    *json.Encoder
+	buf    []interface{}
+}
+
+func NewEncoder(w io.Writer, format Format) *Encoder {
+	return &Encoder{format: format, enc: json.NewEncoder(w)}
+}
+
+func (e *Encoder) Format() Format {
+	return e.format
+}
+
+// Emit records v according to the encoder's format. Callers of
+// multi-result commands (broken, show, restart, delete, data) should
+// call Emit once per result and Close when done.
+func (e *Encoder) Emit(v interface{}) error {
+	switch e.format {
+	case NDJSON:
+		return e.enc.Encode(v)
+	case JSON:
+		e.buf = append(e.buf, v)
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Close flushes any buffered results. It is a no-op outside JSON mode.
+func (e *Encoder) Close() error {
+	if e.format == JSON {
+		return e.enc.Encode(e.buf)
+	}
+	return nil
+}
+
+// EmitOne writes a single result object, used by single-result commands
+// like `count` and `cleanup` where JSON and NDJSON mode behave identically.
+func (e *Encoder) EmitOne(v interface{}) error {
+	return e.enc.Encode(v)
+}
@@ -0,0 +1,88 @@
+//  Copyright 2020 Winfried Klum
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// jsonPathSegment is one step of a jsonb path, e.g. ->'foo' or ->>'bar'.
+type jsonPathSegment struct {
+	key    string
+	asText bool // true for ->>, false for ->
+}
+
+// jsonSelector is a validated, parsed form of the -json-selector flag:
+// a jsonb path, a comparison operator and a literal, e.g.
+// json->'foo'->>'bar' = 'x'. It is built from a strict grammar instead of
+// splicing the raw flag value into the WHERE clause.
+type jsonSelector struct {
+	path     []jsonPathSegment
+	operator string
+	literal  string
+}
+
+var jsonSelectorPattern = regexp.MustCompile(`(?i)^json((?:\s*->>?\s*'[A-Za-z0-9_]+')+)\s*(=|!=|<>|>=|<=|>|<|like)\s*'((?:[^']|'')*)'$`)
+var jsonPathSegmentPattern = regexp.MustCompile(`->(>?)\s*'([A-Za-z0-9_]+)'`)
+
+// parseJSONSelector validates selector and breaks it into a path,
+// operator and literal. It rejects anything that doesn't match the
+// grammar, which in turn rejects statement terminators, comments and
+// unbalanced quotes without needing a standalone blacklist.
+func parseJSONSelector(selector string) (*jsonSelector, error) {
+	selector = strings.TrimSpace(selector)
+	m := jsonSelectorPattern.FindStringSubmatch(selector)
+	if m == nil {
+		return nil, fmt.Errorf("selector must look like json->'key' = 'value' (got %q)", selector)
+	}
+
+	pathPart, operator, literal := m[1], strings.ToLower(m[2]), m[3]
+
+	var path []jsonPathSegment
+	for _, seg := range jsonPathSegmentPattern.FindAllStringSubmatch(pathPart, -1) {
+		path = append(path, jsonPathSegment{key: seg[2], asText: seg[1] == ">"})
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("selector must reference at least one json key")
+	}
+
+	return &jsonSelector{
+		path:     path,
+		operator: operator,
+		literal:  strings.ReplaceAll(literal, "''", "'"),
+	}, nil
+}
+
+// expr renders the path as a SQL fragment rooted at column, with the
+// literal bound as a single "?" placeholder for queryBuilder.and.
+func (s *jsonSelector) expr(column string) string {
+	var sb strings.Builder
+	sb.WriteString(column)
+	for _, seg := range s.path {
+		if seg.asText {
+			sb.WriteString("->>'")
+		} else {
+			sb.WriteString("->'")
+		}
+		sb.WriteString(seg.key)
+		sb.WriteString("'")
+	}
+	sb.WriteString(" ")
+	sb.WriteString(s.operator)
+	sb.WriteString(" ?")
+	return sb.String()
+}
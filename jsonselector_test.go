@@ -0,0 +1,110 @@
+//  Copyright 2020 Winfried Klum
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import "testing"
+
+func TestParseJSONSelectorValid(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		wantPath []jsonPathSegment
+		wantOp   string
+		wantLit  string
+	}{
+		{
+			name:     "single key equals",
+			selector: "json->'foo' = 'bar'",
+			wantPath: []jsonPathSegment{{key: "foo", asText: false}},
+			wantOp:   "=",
+			wantLit:  "bar",
+		},
+		{
+			name:     "as-text operator",
+			selector: "json->>'foo' = 'bar'",
+			wantPath: []jsonPathSegment{{key: "foo", asText: true}},
+			wantOp:   "=",
+			wantLit:  "bar",
+		},
+		{
+			name:     "nested path",
+			selector: "json->'foo'->>'bar' != 'x'",
+			wantPath: []jsonPathSegment{{key: "foo", asText: false}, {key: "bar", asText: true}},
+			wantOp:   "!=",
+			wantLit:  "x",
+		},
+		{
+			name:     "like operator, case insensitive keyword",
+			selector: "json->'foo' LIKE '%bar%'",
+			wantPath: []jsonPathSegment{{key: "foo", asText: false}},
+			wantOp:   "like",
+			wantLit:  "%bar%",
+		},
+		{
+			name:     "escaped quote in literal",
+			selector: "json->'foo' = 'it''s'",
+			wantPath: []jsonPathSegment{{key: "foo", asText: false}},
+			wantOp:   "=",
+			wantLit:  "it's",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseJSONSelector(tt.selector)
+			if err != nil {
+				t.Fatalf("parseJSONSelector(%q) returned error: %v", tt.selector, err)
+			}
+			if len(got.path) != len(tt.wantPath) {
+				t.Fatalf("path length = %d, want %d", len(got.path), len(tt.wantPath))
+			}
+			for i, seg := range got.path {
+				if seg != tt.wantPath[i] {
+					t.Errorf("path[%d] = %+v, want %+v", i, seg, tt.wantPath[i])
+				}
+			}
+			if got.operator != tt.wantOp {
+				t.Errorf("operator = %q, want %q", got.operator, tt.wantOp)
+			}
+			if got.literal != tt.wantLit {
+				t.Errorf("literal = %q, want %q", got.literal, tt.wantLit)
+			}
+		})
+	}
+}
+
+func TestParseJSONSelectorRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+	}{
+		{"statement terminator", "json->'foo' = 'bar'; drop table cop_workflow_instance;"},
+		{"sql comment", "json->'foo' = 'bar' -- comment"},
+		{"unbalanced quote", "json->'foo' = 'bar"},
+		{"no path", "json = 'bar'"},
+		{"invalid key characters", "json->'foo bar' = 'x'"},
+		{"invalid operator", "json->'foo' ~ 'x'"},
+		{"missing column prefix", "->'foo' = 'bar'"},
+		{"empty selector", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseJSONSelector(tt.selector); err == nil {
+				t.Errorf("parseJSONSelector(%q) = nil error, want rejection", tt.selector)
+			}
+		})
+	}
+}
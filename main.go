@@ -0,0 +1,114 @@
+//  Copyright 2020 Winfried Klum
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/klumw/cpt/internal/output"
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+)
+
+func main() {
+	if err := buildApp().Run(os.Args); err != nil {
+		exitOnErr("%v", err)
+	}
+}
+
+// buildApp wires every subcommand onto a single root app so DATABASE_URL,
+// -output, -timeout and -config have one consistent place to hang flags,
+// replacing the old switch on os.Args[1] plus per-command flag.FlagSets.
+func buildApp() *cli.App {
+	globalFlags := []cli.Flag{
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name: "database-url", EnvVars: []string{"DATABASE_URL"}, Usage: "Postgres connection string",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name: "output", Value: string(output.Text), Usage: "result format: text, json or ndjson",
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name: "timeout", Value: 30 * time.Second, Usage: "timeout for connecting to the database",
+		}),
+		&cli.StringFlag{
+			Name: "config", Usage: "path to a YAML file with default values for the flags above",
+		},
+	}
+
+	return &cli.App{
+		Name:                 "cpt",
+		Usage:                "Copper Postgres Tool",
+		EnableBashCompletion: true,
+		Flags:                globalFlags,
+		Commands: []*cli.Command{
+			newCountCommand(),
+			newBrokenCommand(),
+			newShowCommand(),
+			newDeleteCommand(),
+			newRestartCommand(),
+			newDataCommand(),
+			newCleanupCommand(),
+			newServeCommand(),
+			newTailCommand(),
+			newCompletionCommand(),
+		},
+		Before: func(c *cli.Context) error {
+			if err := altsrc.InitInputSourceWithContext(globalFlags, altsrc.NewYamlSourceFromFlagFunc("config"))(c); err != nil {
+				return err
+			}
+			if _, err := output.ParseFormat(c.String("output")); err != nil {
+				return err
+			}
+			if c.Args().First() == "completion" {
+				return nil
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), c.Duration("timeout"))
+			defer cancel()
+			var err error
+			conn, err = pgx.Connect(ctx, c.String("database-url"))
+			if err != nil {
+				return fmt.Errorf("unable to connect to database: %w\nMake sure -database-url or DATABASE_URL is set", err)
+			}
+			return nil
+		},
+		After: func(c *cli.Context) error {
+			if conn != nil {
+				return conn.Close(context.Background())
+			}
+			return nil
+		},
+	}
+}
+
+// outputFormat resolves the -output flag for the current command,
+// inherited from the root app via cli's context lineage.
+func outputFormat(c *cli.Context) output.Format {
+	format, err := output.ParseFormat(c.String("output"))
+	if err != nil {
+		exitOnErr("%v", err)
+	}
+	return format
+}
+
+// idArgs returns a command's positional workflow-instance-id arguments,
+// folding in any ids piped over stdin as the original flag-based CLI did.
+func idArgs(c *cli.Context) []string {
+	return append(c.Args().Slice(), readPipe()...)
+}
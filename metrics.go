@@ -0,0 +1,176 @@
+//  Copyright 2020 Winfried Klum
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type metricsCollector struct {
+	registry         *prometheus.Registry
+	workflowInstance *prometheus.GaugeVec
+	brokenTotal      *prometheus.GaugeVec
+	oldestError      prometheus.Gauge
+	queueDepth       *prometheus.GaugeVec
+}
+
+func newMetricsCollector() *metricsCollector {
+	registry := prometheus.NewRegistry()
+
+	c := &metricsCollector{
+		registry: registry,
+		workflowInstance: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "copper_workflow_instances",
+			Help: "Number of Copper workflow instances per state.",
+		}, []string{"state"}),
+		brokenTotal: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "copper_workflow_broken_total",
+			Help: "Number of broken workflow instances per workflow class.",
+		}, []string{"classname"}),
+		oldestError: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "copper_workflow_oldest_error_seconds",
+			Help: "Age in seconds of the oldest unresolved workflow instance error.",
+		}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "copper_queue_depth",
+			Help: "Number of queued workflow instances per processing pool.",
+		}, []string{"ppool_id"}),
+	}
+
+	registry.MustRegister(c.workflowInstance, c.brokenTotal, c.oldestError, c.queueDepth)
+	return c
+}
+
+// collect queries the Copper schema and refreshes all gauges. It never
+// exits the process on error so that a transient DB hiccup doesn't bring
+// the exporter down; the readiness endpoint reflects connectivity instead.
+func (c *metricsCollector) collect(ctx context.Context) {
+	rows, err := conn.Query(ctx, "select state, count(id) from cop_workflow_instance group by state")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "metrics: error counting workflow instances: %v\n", err)
+	} else {
+		c.workflowInstance.Reset()
+		for rows.Next() {
+			var state, count int
+			if err := rows.Scan(&state, &count); err != nil {
+				continue
+			}
+			stateTxt, err := indexState(state)
+			if err != nil {
+				continue
+			}
+			c.workflowInstance.WithLabelValues(stateTxt).Set(float64(count))
+		}
+	}
+
+	rows, err = conn.Query(ctx, "select i.classname, count(*) from cop_workflow_instance_error e, cop_workflow_instance i where e.workflow_instance_id=i.id group by i.classname")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "metrics: error counting broken instances: %v\n", err)
+	} else {
+		c.brokenTotal.Reset()
+		for rows.Next() {
+			var classname string
+			var count int
+			if err := rows.Scan(&classname, &count); err != nil {
+				continue
+			}
+			c.brokenTotal.WithLabelValues(classname).Set(float64(count))
+		}
+	}
+
+	var oldest *time.Time
+	err = conn.QueryRow(ctx, "select min(error_ts) from cop_workflow_instance_error").Scan(&oldest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "metrics: error reading oldest error: %v\n", err)
+	} else if oldest != nil {
+		c.oldestError.Set(time.Since(*oldest).Seconds())
+	} else {
+		c.oldestError.Set(0)
+	}
+
+	rows, err = conn.Query(ctx, "select ppool_id, count(*) from cop_queue group by ppool_id")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "metrics: error counting queue depth: %v\n", err)
+	} else {
+		c.queueDepth.Reset()
+		for rows.Next() {
+			var ppoolID string
+			var count int
+			if err := rows.Scan(&ppoolID, &count); err != nil {
+				continue
+			}
+			c.queueDepth.WithLabelValues(ppoolID).Set(float64(count))
+		}
+	}
+}
+
+// serve starts the Prometheus metrics exporter. It blocks until the
+// process receives SIGINT/SIGTERM.
+func serve(listenAddr string, scrapeInterval time.Duration) {
+	collector := newMetricsCollector()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(collector.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := conn.Ping(r.Context()); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "database unreachable: %v\n", err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	})
+
+	server := &http.Server{Addr: listenAddr, Handler: mux}
+
+	go func() {
+		fmt.Fprintf(os.Stdout, "serving Copper metrics on %v/metrics (scrape interval %v)\n", listenAddr, scrapeInterval)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			exitOnErr("Error starting metrics server: %v", err)
+		}
+	}()
+
+	ticker := time.NewTicker(scrapeInterval)
+	defer ticker.Stop()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	collector.collect(context.Background())
+	for {
+		select {
+		case <-ticker.C:
+			collector.collect(context.Background())
+		case <-sigCh:
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+//  Copyright 2020 Winfried Klum
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// queryBuilder assembles a parameterized SQL statement from a base clause
+// and a series of "and" fragments, rewriting each "?" placeholder in a
+// fragment to the next positional $N argument. It replaces the previous
+// pattern of splicing filter values directly into a bytes.Buffer.
+type queryBuilder struct {
+	sb   strings.Builder
+	args []interface{}
+}
+
+func newQueryBuilder(base string) *queryBuilder {
+	qb := &queryBuilder{}
+	qb.sb.WriteString(base)
+	return qb
+}
+
+// and appends " and <fragment>" to the query, replacing each "?" in
+// fragment with the next positional placeholder and recording the
+// corresponding argument in order.
+func (qb *queryBuilder) and(fragment string, args ...interface{}) {
+	qb.sb.WriteString(" and ")
+	for _, a := range args {
+		qb.args = append(qb.args, a)
+		placeholder := "$" + strconv.Itoa(len(qb.args))
+		fragment = strings.Replace(fragment, "?", placeholder, 1)
+	}
+	qb.sb.WriteString(fragment)
+}
+
+// orderBy appends a literal " order by <clause>" fragment. clause never
+// comes from user input in this codebase, so it takes no arguments.
+func (qb *queryBuilder) orderBy(clause string) {
+	qb.sb.WriteString(" order by ")
+	qb.sb.WriteString(clause)
+}
+
+func (qb *queryBuilder) String() string {
+	return qb.sb.String()
+}
+
+func (qb *queryBuilder) Args() []interface{} {
+	return qb.args
+}
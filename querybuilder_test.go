@@ -0,0 +1,64 @@
+//  Copyright 2020 Winfried Klum
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import "testing"
+
+func TestQueryBuilderPlaceholderNumbering(t *testing.T) {
+	qb := newQueryBuilder("select id from cop_workflow_instance where true")
+	qb.and("classname=?", "com.example.Foo")
+	qb.and("state=?", 5)
+	qb.and("error_ts >= ? and error_ts <= ?", "2020-01-01", "2020-02-01")
+
+	wantSQL := "select id from cop_workflow_instance where true" +
+		" and classname=$1" +
+		" and state=$2" +
+		" and error_ts >= $3 and error_ts <= $4"
+	if got := qb.String(); got != wantSQL {
+		t.Errorf("String() = %q, want %q", got, wantSQL)
+	}
+
+	wantArgs := []interface{}{"com.example.Foo", 5, "2020-01-01", "2020-02-01"}
+	gotArgs := qb.Args()
+	if len(gotArgs) != len(wantArgs) {
+		t.Fatalf("Args() length = %d, want %d", len(gotArgs), len(wantArgs))
+	}
+	for i, want := range wantArgs {
+		if gotArgs[i] != want {
+			t.Errorf("Args()[%d] = %v, want %v", i, gotArgs[i], want)
+		}
+	}
+}
+
+func TestQueryBuilderNoFilters(t *testing.T) {
+	qb := newQueryBuilder("select count(*) from cop_workflow_instance")
+	if got, want := qb.String(), "select count(*) from cop_workflow_instance"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if len(qb.Args()) != 0 {
+		t.Errorf("Args() = %v, want empty", qb.Args())
+	}
+}
+
+func TestQueryBuilderOrderBy(t *testing.T) {
+	qb := newQueryBuilder("select id from cop_workflow_instance where true")
+	qb.and("state=?", 5)
+	qb.orderBy("id")
+
+	want := "select id from cop_workflow_instance where true and state=$1 order by id"
+	if got := qb.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+//  Copyright 2020 Winfried Klum
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+const (
+	retryBaseDelay   = 100 * time.Millisecond
+	retryMaxDelay    = 5 * time.Second
+	retryMaxAttempts = 5
+)
+
+// retryableSQLStates are the SQLSTATE codes worth retrying a transaction
+// for: concurrent transactions stepping on each other, not a real failure.
+var retryableSQLStates = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+}
+
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return retryableSQLStates[pgErr.Code]
+	}
+	return false
+}
+
+// withRetry runs fn with exponential backoff (base 100ms, max 5s, at most
+// 5 attempts), retrying only on serialization_failure / deadlock_detected.
+// fn is expected to open its own pgx.Tx and commit or rollback it before
+// returning, since a retried attempt must start from a fresh transaction.
+func withRetry(ctx context.Context, fn func(ctx context.Context) error) error {
+	delay := retryBaseDelay
+	var err error
+	for attempt := 1; attempt <= retryMaxAttempts; attempt++ {
+		err = fn(ctx)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+		if attempt == retryMaxAttempts {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+	return err
+}
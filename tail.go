@@ -0,0 +1,198 @@
+//  Copyright 2020 Winfried Klum
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/klumw/cpt/internal/output"
+)
+
+const (
+	tailErrorChannel = "copper_error"
+	tailAuditChannel = "copper_audit"
+)
+
+// installTailTriggers installs the (idempotent) DDL that lets `tail` learn
+// about new broken workflow instances via LISTEN/NOTIFY instead of
+// polling. Safe to run more than once.
+func installTailTriggers() {
+	ctx := context.Background()
+	statements := []string{
+		`CREATE OR REPLACE FUNCTION copper_notify_error() RETURNS trigger AS $$
+			BEGIN
+				PERFORM pg_notify('` + tailErrorChannel + `', row_to_json(NEW)::text);
+				RETURN NEW;
+			END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS copper_notify_error_trigger ON cop_workflow_instance_error`,
+		`CREATE TRIGGER copper_notify_error_trigger AFTER INSERT ON cop_workflow_instance_error FOR EACH ROW EXECUTE PROCEDURE copper_notify_error()`,
+		`CREATE OR REPLACE FUNCTION copper_notify_audit() RETURNS trigger AS $$
+			BEGIN
+				PERFORM pg_notify('` + tailAuditChannel + `', row_to_json(NEW)::text);
+				RETURN NEW;
+			END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS copper_notify_audit_trigger ON cop_audit_trail_event`,
+		`CREATE TRIGGER copper_notify_audit_trigger AFTER INSERT ON cop_audit_trail_event FOR EACH ROW EXECUTE PROCEDURE copper_notify_audit()`,
+	}
+	for _, stmt := range statements {
+		if _, err := conn.Exec(ctx, stmt); err != nil {
+			exitOnErr("Error installing tail triggers: %v", err)
+		}
+	}
+	fmt.Fprintln(os.Stdout, "installed copper_error/copper_audit triggers")
+}
+
+// tail streams newly-broken workflow instances by polling
+// cop_workflow_instance_error on every pollInterval tick, and additionally
+// right away whenever a LISTEN/NOTIFY notification arrives. Without the
+// triggers (or when the LISTEN connection can't be established) only the
+// interval polling remains.
+func tail(databaseURL string, pollInterval time.Duration, filterClass, filterState, exceptionPattern string, format output.Format) {
+	if format == output.JSON {
+		// A continuous stream never reaches output.Encoder.Close, so the
+		// buffered JSON-array mode would never flush; ndjson streams one
+		// object per line instead and is what this command actually needs.
+		format = output.NDJSON
+	}
+
+	ctx := context.Background()
+	wake := make(chan struct{}, 1)
+	go listenForNewErrors(ctx, databaseURL, wake)
+
+	enc := output.NewEncoder(os.Stdout, format)
+	since := time.Now()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	poll := func() {
+		since = pollBrokenSince(ctx, enc, format, since, filterClass, filterState, exceptionPattern)
+	}
+
+	poll()
+	for {
+		select {
+		case <-ticker.C:
+			poll()
+		case <-wake:
+			poll()
+		case <-sigCh:
+			return
+		}
+	}
+}
+
+// listenForNewErrors opens a dedicated connection (LISTEN blocks the
+// connection it runs on, so it can't share the main query connection) and
+// nudges wake every time a copper_error/copper_audit notification arrives.
+// It returns after a message on stderr if LISTEN can't be established, leaving the poll
+// ticker in tail() as the sole source of updates. databaseURL is the
+// resolved -database-url/-config/DATABASE_URL value from the root app,
+// not re-read from the environment, so LISTEN targets the same database
+// as every other subcommand.
+func listenForNewErrors(ctx context.Context, databaseURL string, wake chan<- struct{}) {
+	listenConn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tail: LISTEN unavailable, falling back to polling only: %v\n", err)
+		return
+	}
+	defer listenConn.Close(ctx)
+
+	if _, err := listenConn.Exec(ctx, "listen "+tailErrorChannel); err != nil {
+		fmt.Fprintf(os.Stderr, "tail: LISTEN unavailable, falling back to polling only: %v\n", err)
+		return
+	}
+	if _, err := listenConn.Exec(ctx, "listen "+tailAuditChannel); err != nil {
+		fmt.Fprintf(os.Stderr, "tail: LISTEN unavailable, falling back to polling only: %v\n", err)
+		return
+	}
+
+	for {
+		if _, err := listenConn.WaitForNotification(ctx); err != nil {
+			return
+		}
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// pollBrokenSince fetches broken workflow instances reported after since,
+// emits them and returns the newest error_ts observed (or since unchanged
+// if nothing new was found).
+func pollBrokenSince(ctx context.Context, enc *output.Encoder, format output.Format, since time.Time, filterClass, filterState, exceptionPattern string) time.Time {
+	qb := newQueryBuilder("select e.workflow_instance_id, i.classname, e.error_ts, e.exception from cop_workflow_instance_error as e, cop_workflow_instance as i where e.workflow_instance_id=i.id")
+	qb.and("e.error_ts > ?", since)
+
+	if len(filterClass) > 0 {
+		if !validClassName.MatchString(filterClass) {
+			exitOnErr("Invalid filter-class: %v", filterClass)
+		}
+		qb.and("i.classname=?", filterClass)
+	}
+	if len(exceptionPattern) > 0 {
+		if !validLike.MatchString(exceptionPattern) {
+			exitOnErr("Invalid exception-pattern: %v", exceptionPattern)
+		}
+		qb.and("e.exception like ?", "%"+exceptionPattern+"%")
+	}
+	if len(filterState) > 0 {
+		stateIdx := stateIndex(filterState)
+		if stateIdx < 0 {
+			exitOnErr("Invalid filter-state: %v. Allowed states are: %v", filterState, fmt.Sprint(states))
+		}
+		if states[stateIdx] != "ALL" {
+			qb.and("i.state=?", stateIdx)
+		}
+	}
+	qb.orderBy("e.error_ts asc")
+
+	rows, err := conn.Query(ctx, qb.String(), qb.Args()...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tail: error polling broken instances: %v\n", err)
+		return since
+	}
+
+	newest := since
+	for rows.Next() {
+		var id, classname, exception string
+		var errorTs time.Time
+		if err := rows.Scan(&id, &classname, &errorTs, &exception); err != nil {
+			fmt.Fprintf(os.Stderr, "tail: error reading broken instance: %v\n", err)
+			continue
+		}
+		if format == output.Text {
+			fmt.Printf("%v\t%v\t%v\t%v\n", errorTs.Format(pgTimestampFormat), id, classname, exception)
+		} else {
+			enc.Emit(output.BrokenResult{ID: id, Classname: classname, ErrorTs: errorTs.Format(pgTimestampFormat), Exception: exception})
+		}
+		if errorTs.After(newest) {
+			newest = errorTs
+		}
+	}
+	return newest
+}